@@ -2,24 +2,35 @@ package backup
 
 import (
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
-	"io/ioutil"
-	"os"
 	"path"
 	"runtime"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/golang/snappy"
 	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
 	"github.com/klauspost/pgzip"
-	"github.com/minio/minio-go"
 	"github.com/pierrec/lz4"
 	"github.com/pkg/errors"
 
+	"github.com/golang/snappy"
+
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/factory"
+
+	// driver registration
+	_ "github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/azure"
+	_ "github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/blackhole"
+	_ "github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/fs"
+	_ "github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/gcs"
+	_ "github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/s3"
 )
 
 // NopCloser wraps an io.Witer as io.WriteCloser
@@ -31,8 +42,29 @@ type NopCloser struct {
 // Close to satisfy io.WriteCloser interface
 func (NopCloser) Close() error { return nil }
 
+// CompressOptions carries backend-specific compressor tuning that
+// doesn't fit the compression, writer pair, such as the zstd level and
+// dictionary read from pbm.Storage's compression config. It's variadic
+// at the call site so existing Compress(w, compression) callers are
+// unaffected.
+type CompressOptions struct {
+	// ZSTDLevel selects the zstd speed/ratio tradeoff, e.g.
+	// zstd.SpeedFastest .. zstd.SpeedBestCompression. Zero means the
+	// library default.
+	ZSTDLevel zstd.EncoderLevel
+	// ZSTDDictionary is trained dictionary bytes, loaded by the caller
+	// from the path in config, that dramatically improves ratio on
+	// small oplog chunks.
+	ZSTDDictionary []byte
+}
+
 // Compress makes a compressed the writer from given one
-func Compress(w io.Writer, compression pbm.CompressionType) io.WriteCloser {
+func Compress(w io.Writer, compression pbm.CompressionType, opts ...CompressOptions) io.WriteCloser {
+	var o CompressOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
 	switch compression {
 	case pbm.CompressionTypeGZIP:
 		return gzip.NewWriter(w)
@@ -54,66 +86,153 @@ func Compress(w io.Writer, compression pbm.CompressionType) io.WriteCloser {
 			cc = 1
 		}
 		return s2.NewWriter(w, s2.WriterConcurrency(cc))
+	case pbm.CompressionTypeZSTD:
+		zopts := []zstd.EOption{zstd.WithEncoderConcurrency(runtime.NumCPU())}
+		if o.ZSTDLevel != 0 {
+			zopts = append(zopts, zstd.WithEncoderLevel(o.ZSTDLevel))
+		}
+		if len(o.ZSTDDictionary) > 0 {
+			zopts = append(zopts, zstd.WithEncoderDict(o.ZSTDDictionary))
+		}
+		zw, err := zstd.NewWriter(w, zopts...)
+		if err != nil {
+			return errWriteCloser{errors.Wrap(err, "zstd writer")}
+		}
+		return zw
 	default:
 		return NopCloser{w}
 	}
 }
 
-// Save writes data to given store
-func Save(data io.Reader, stg pbm.Storage, name string) error {
-	switch stg.Type {
-	case pbm.StorageFilesystem:
-		filepath := path.Join(stg.Filesystem.Path, name)
-		fw, err := os.Create(filepath)
-		if err != nil {
-			return errors.Wrapf(err, "create destination file <%s>", filepath)
-		}
-		_, err = io.Copy(fw, data)
-		return errors.Wrap(err, "write to file")
-	case pbm.StorageS3:
-		switch stg.S3.Provider {
-		default:
-			awsSession, err := session.NewSession(&aws.Config{
-				Region:   aws.String(stg.S3.Region),
-				Endpoint: aws.String(stg.S3.EndpointURL),
-				Credentials: credentials.NewStaticCredentials(
-					stg.S3.Credentials.AccessKeyID,
-					stg.S3.Credentials.SecretAccessKey,
-					"",
-				),
-				S3ForcePathStyle: aws.Bool(true),
-			})
-			if err != nil {
-				return errors.Wrap(err, "create AWS session")
-			}
-			cc := runtime.NumCPU() / 2
-			if cc == 0 {
-				cc = 1
-			}
-			_, err = s3manager.NewUploader(awsSession, func(u *s3manager.Uploader) {
-				u.PartSize = 10 * 1024 * 1024 // 10MB part size
-				u.LeavePartsOnError = true    // Don't delete the parts if the upload fails.
-				u.Concurrency = cc
-			}).Upload(&s3manager.UploadInput{
-				Bucket: aws.String(stg.S3.Bucket),
-				Key:    aws.String(path.Join(stg.S3.Prefix, name)),
-				Body:   data,
-			})
-			return errors.Wrap(err, "upload to S3")
-		case pbm.S3ProviderGCS:
-			// using minio client with GCS because it
-			// allows to disable chuncks muiltipertition for upload
-			mc, err := minio.NewWithRegion(pbm.GCSEndpointURL, stg.S3.Credentials.AccessKeyID, stg.S3.Credentials.SecretAccessKey, true, stg.S3.Region)
-			if err != nil {
-				return errors.Wrap(err, "NewWithRegion")
-			}
-			_, err = mc.PutObject(stg.S3.Bucket, path.Join(stg.S3.Prefix, name), data, -1, minio.PutObjectOptions{})
-			return errors.Wrap(err, "upload to GCS")
+// errWriteCloser is returned when a compressor can't be constructed, so
+// Compress can keep returning a bare io.WriteCloser and the error still
+// surfaces on the first Write.
+type errWriteCloser struct {
+	err error
+}
+
+func (e errWriteCloser) Write([]byte) (int, error) { return 0, e.err }
+func (e errWriteCloser) Close() error              { return nil }
+
+// ManifestSuffix is appended to an object's name to derive the path of
+// its checksum manifest (see writeChecksumManifest), so List-based
+// consumers (e.g. orphan GC) can recognize and skip manifests instead
+// of treating them as backup objects in their own right.
+const ManifestSuffix = ".sha256"
+
+// SaveResult summarizes a completed Save: the size actually written and
+// the checksums computed from the exact bytes streamed out, so
+// pbm-agent can verify integrity before a restore trusts the object.
+type SaveResult struct {
+	Size   int64
+	SHA256 string
+	// CRC32C is the base64-encoded (not hex) Castagnoli CRC32C of the
+	// whole object, matching the wire encoding of S3's
+	// x-amz-checksum-crc32c. For a multipart object this is a
+	// client-side whole-object checksum, not S3's own composite-of-parts
+	// value, so it must be compared against a recompute over the full
+	// restored stream, never against S3's reported checksum directly.
+	CRC32C string
+}
+
+// SaveOptions carries Save behavior that most callers don't need to
+// think about, so the common call site stays Save(data, stg, name). It's
+// variadic at the call site for the same reason CompressOptions is.
+type SaveOptions struct {
+	// Manifest writes a sibling checksum manifest (see
+	// writeChecksumManifest) next to the object. It defaults to off:
+	// full backup files want it, but the PITR oplog applier saves one
+	// small chunk per slice and a manifest would double its S3 calls for
+	// no practical benefit. The blackhole driver never gets a manifest
+	// regardless of this option, since there's nothing to verify.
+	Manifest bool
+}
+
+// Save writes data to given store. It resolves a storagedriver.Driver
+// for stg.Type via the factory registry and streams data to it, rather
+// than switching on the storage type itself - new backends only need to
+// register a driver, not a new case here.
+//
+// The stream is tee'd through a CRC32C/SHA256 hasher as it's written, so
+// every backend gets the same client-side checksums rather than each
+// computing (or not computing) them on its own. S3 additionally gets
+// the native x-amz-checksum-crc32c validation per part, set in the
+// multipart driver itself, but only when stg.S3.EnableChecksum is set;
+// it is off by default and never applies to the minio-based GCS
+// provider path. See SaveOptions.Manifest for when a sibling manifest
+// is written next to the object.
+func Save(data io.Reader, stg pbm.Storage, name string, opts ...SaveOptions) (SaveResult, error) {
+	var o SaveOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	driver, err := factory.Create(stg)
+	if err != nil {
+		return SaveResult{}, errors.Wrap(err, "create storage driver")
+	}
+
+	w, err := driver.Writer(context.Background(), name, false)
+	if err != nil {
+		return SaveResult{}, errors.Wrapf(err, "open writer for <%s>", name)
+	}
+
+	hr := newHashingReader(data)
+	n, err := io.Copy(w, hr)
+	if err != nil {
+		w.Close()
+		return SaveResult{}, errors.Wrapf(err, "write to %s", driver.Name())
+	}
+	if err := w.Close(); err != nil {
+		return SaveResult{}, errors.Wrapf(err, "close writer for %s", driver.Name())
+	}
+
+	res := SaveResult{
+		Size:   n,
+		SHA256: hex.EncodeToString(hr.sha256.Sum(nil)),
+		CRC32C: base64.StdEncoding.EncodeToString(hr.crc32c.Sum(nil)),
+	}
+
+	if o.Manifest && driver.Name() != string(pbm.StorageBlackHole) {
+		if err := writeChecksumManifest(driver, name, res); err != nil {
+			return res, errors.Wrap(err, "write checksum manifest")
 		}
-	case pbm.StorageBlackHole:
-		_, err := io.Copy(ioutil.Discard, data)
-		return errors.Wrap(err, "upload to blackhole")
-	default:
-		return errors.New("unknown storage type")
 	}
+
+	return res, nil
+}
+
+// hashingReader tees every byte read through SHA256 and CRC32C hashers
+// so Save can report SaveResult without buffering the stream.
+type hashingReader struct {
+	io.Reader
+	sha256 hash.Hash
+	crc32c hash.Hash32
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	hr := &hashingReader{
+		sha256: sha256.New(),
+		crc32c: crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+	}
+	hr.Reader = io.TeeReader(r, io.MultiWriter(hr.sha256, hr.crc32c))
+	return hr
+}
+
+// writeChecksumManifest writes a sibling "<name>.sha256" file in the
+// sha256sum(1) format, so integrity can be checked without parsing
+// backup metadata.
+func writeChecksumManifest(driver storagedriver.Driver, name string, res SaveResult) error {
+	mw, err := driver.Writer(context.Background(), name+ManifestSuffix, false)
+	if err != nil {
+		return errors.Wrap(err, "open manifest writer")
+	}
+
+	_, err = fmt.Fprintf(mw, "%s  %s\n", res.SHA256, path.Base(name))
+	if err != nil {
+		mw.Close()
+		return errors.Wrap(err, "write manifest")
+	}
+
+	return mw.Close()
 }
@@ -0,0 +1,218 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4"
+	"github.com/pkg/errors"
+
+	"github.com/golang/snappy"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/factory"
+)
+
+const (
+	readRetries = 5
+	// maxReopens bounds the total number of times a retryReader may
+	// re-open its underlying stream over its whole lifetime, not just
+	// per Read call. Without it, a reopen that keeps "succeeding" but
+	// never makes progress (e.g. an endpoint that accepts the ranged
+	// request yet returns nothing useful) retries forever.
+	maxReopens = 20
+)
+
+// Source returns a reader streaming name from stg from the beginning.
+// It mirrors Save: restore code reads through the same driver factory
+// backup writes through.
+func Source(stg pbm.Storage, name string) (io.ReadCloser, error) {
+	return Open(stg, name, 0)
+}
+
+// Open returns a reader streaming name from stg starting at offset. The
+// returned reader transparently retries transient mid-stream failures
+// by re-opening a ranged read from the last successfully read byte, so
+// restoring multi-hundred-GB backups survives flaky networks.
+func Open(stg pbm.Storage, name string, offset int64) (io.ReadCloser, error) {
+	driver, err := factory.Create(stg)
+	if err != nil {
+		return nil, errors.Wrap(err, "create storage driver")
+	}
+
+	r, err := driver.Reader(context.Background(), name, offset)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open reader for <%s>", name)
+	}
+
+	return &retryReader{
+		driver: driver,
+		name:   name,
+		offset: offset,
+		r:      r,
+	}, nil
+}
+
+// retryReader wraps a storagedriver.Driver reader and, on a transient
+// read error, re-issues Reader(ctx, name, offset) from the last byte
+// successfully delivered to the caller instead of surfacing the error.
+type retryReader struct {
+	driver  storagedriver.Driver
+	name    string
+	offset  int64
+	r       io.ReadCloser
+	reopens int
+}
+
+func (rr *retryReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.offset += int64(n)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	if isPermanent(err) {
+		return n, errors.Wrapf(err, "read <%s>", rr.name)
+	}
+
+	if rerr := rr.reopen(); rerr != nil {
+		return n, errors.Wrapf(err, "read <%s> (reopen failed: %s)", rr.name, rerr)
+	}
+	return n, nil
+}
+
+func (rr *retryReader) reopen() error {
+	if rr.reopens >= maxReopens {
+		return errors.Errorf("exceeded %d reopen attempts for <%s>", maxReopens, rr.name)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < readRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
+		}
+
+		rr.r.Close()
+		r, err := rr.driver.Reader(context.Background(), rr.name, rr.offset)
+		if err == nil {
+			rr.r = r
+			rr.reopens++
+			return nil
+		}
+		if isPermanent(err) {
+			return err
+		}
+		lastErr = err
+	}
+	return errors.Wrapf(lastErr, "reopen <%s> at offset %d", rr.name, rr.offset)
+}
+
+// isPermanent reports whether err is a storage error that a retry can
+// never fix - a missing object, bad credentials, and the like - as
+// opposed to a transient network blip worth reopening for. Only S3
+// (awserr) error codes are recognized; errors from other backends, such
+// as a missing file on the filesystem driver, are treated as transient.
+func isPermanent(err error) bool {
+	type causer interface{ Cause() error }
+	for err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case "NoSuchKey", "NoSuchBucket", "AccessDenied", "Forbidden",
+				"InvalidAccessKeyId", "SignatureDoesNotMatch", "InvalidArgument":
+				return true
+			}
+			return false
+		}
+		c, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
+func (rr *retryReader) Close() error {
+	return rr.r.Close()
+}
+
+// DecompressOptions carries the decoder-side counterpart of
+// CompressOptions - currently just the dictionary, since zstd picks its
+// decode strategy from the frame header regardless of the encoder
+// level used to produce it.
+type DecompressOptions struct {
+	ZSTDDictionary []byte
+}
+
+// Decompress makes a decompressing reader counterpart to Compress, so
+// restore code can pick the right decoder by pbm.CompressionType
+// without its own switch.
+func Decompress(r io.Reader, compression pbm.CompressionType, opts ...DecompressOptions) io.ReadCloser {
+	var o DecompressOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	switch compression {
+	case pbm.CompressionTypeGZIP:
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return errReadCloser{errors.Wrap(err, "gzip reader")}
+		}
+		return gzr
+	case pbm.CompressionTypePGZIP:
+		pgzr, err := pgzip.NewReader(r)
+		if err != nil {
+			return errReadCloser{errors.Wrap(err, "pgzip reader")}
+		}
+		return pgzr
+	case pbm.CompressionTypeLZ4:
+		return ioutil.NopCloser(lz4.NewReader(r))
+	case pbm.CompressionTypeSNAPPY:
+		return ioutil.NopCloser(snappy.NewReader(r))
+	case pbm.CompressionTypeS2:
+		return ioutil.NopCloser(s2.NewReader(r))
+	case pbm.CompressionTypeZSTD:
+		zopts := []zstd.DOption{zstd.WithDecoderConcurrency(runtime.NumCPU())}
+		if len(o.ZSTDDictionary) > 0 {
+			zopts = append(zopts, zstd.WithDecoderDicts(o.ZSTDDictionary))
+		}
+		zr, err := zstd.NewReader(r, zopts...)
+		if err != nil {
+			return errReadCloser{errors.Wrap(err, "zstd reader")}
+		}
+		return zstdReadCloser{zr}
+	default:
+		return ioutil.NopCloser(r)
+	}
+}
+
+// zstdReadCloser adapts *zstd.Decoder (whose Close takes no error) to
+// io.ReadCloser.
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// errReadCloser is returned by Decompress when the underlying decoder
+// can't even be constructed (e.g. a corrupt gzip header), so callers
+// always get an io.ReadCloser and see the error on the first Read.
+type errReadCloser struct {
+	err error
+}
+
+func (e errReadCloser) Read(p []byte) (int, error) { return 0, e.err }
+func (e errReadCloser) Close() error                { return nil }
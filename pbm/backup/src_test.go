@@ -0,0 +1,122 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver"
+)
+
+// fakeReader is an io.ReadCloser that serves from a fixed byte slice and
+// then returns a caller-supplied error once exhausted, instead of EOF.
+// With a nil error it returns io.EOF alongside the last bytes.
+type fakeReader struct {
+	data []byte
+	err  error
+}
+
+func (r *fakeReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	if len(r.data) == 0 && r.err == nil {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+// fakeDriver implements storagedriver.Driver, serving Reader from a
+// caller-supplied func so tests can control what a reopen returns.
+type fakeDriver struct {
+	reader func(offset int64) (io.ReadCloser, error)
+}
+
+func (d *fakeDriver) Name() string { return "fake" }
+func (d *fakeDriver) Writer(ctx context.Context, name string, append bool) (io.WriteCloser, error) {
+	return nil, errors.New("not implemented")
+}
+func (d *fakeDriver) Reader(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	return d.reader(offset)
+}
+func (d *fakeDriver) Stat(ctx context.Context, name string) (storagedriver.FileInfo, error) {
+	return storagedriver.FileInfo{}, errors.New("not implemented")
+}
+func (d *fakeDriver) List(ctx context.Context, prefix string) ([]storagedriver.FileInfo, error) {
+	return nil, errors.New("not implemented")
+}
+func (d *fakeDriver) Delete(ctx context.Context, name string) error {
+	return errors.New("not implemented")
+}
+
+func TestRetryReaderAdvancesOffset(t *testing.T) {
+	rr := &retryReader{
+		driver: &fakeDriver{},
+		name:   "chunk",
+		r:      &fakeReader{data: []byte("hello world")},
+	}
+
+	buf := make([]byte, 5)
+	n, err := rr.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if n != 5 || rr.offset != 5 {
+		t.Fatalf("after first Read: n=%d offset=%d, want n=5 offset=5", n, rr.offset)
+	}
+
+	n, err = rr.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if n != 5 || rr.offset != 10 {
+		t.Fatalf("after second Read: n=%d offset=%d, want n=5 offset=10", n, rr.offset)
+	}
+
+	n, err = rr.Read(buf)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF at end of stream, got %v", err)
+	}
+	if n != 1 || rr.offset != 11 {
+		t.Fatalf("after final Read: n=%d offset=%d, want n=1 offset=11", n, rr.offset)
+	}
+}
+
+func TestRetryReaderReopenCap(t *testing.T) {
+	transientErr := errors.New("connection reset")
+	reopens := 0
+	rr := &retryReader{
+		name: "chunk",
+		driver: &fakeDriver{
+			reader: func(offset int64) (io.ReadCloser, error) {
+				reopens++
+				return &fakeReader{err: transientErr}, nil
+			},
+		},
+		r: &fakeReader{err: transientErr},
+	}
+
+	buf := make([]byte, 1)
+	var err error
+	for i := 0; i < maxReopens; i++ {
+		_, err = rr.Read(buf)
+		if err != nil {
+			t.Fatalf("unexpected error before reopen cap reached (read %d): %v", i, err)
+		}
+	}
+
+	if rr.reopens != maxReopens {
+		t.Fatalf("rr.reopens = %d, want %d", rr.reopens, maxReopens)
+	}
+
+	_, err = rr.Read(buf)
+	if err == nil {
+		t.Fatal("expected an error once the reopen cap is exceeded, got nil")
+	}
+}
@@ -0,0 +1,68 @@
+// Package blackhole implements a storagedriver.Driver that discards
+// everything written to it, useful for benchmarking and dry-run backups.
+package blackhole
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/factory"
+)
+
+type driverFactory struct{}
+
+func (driverFactory) Create(stg pbm.Storage) (storagedriver.Driver, error) {
+	return &Driver{}, nil
+}
+
+func init() {
+	factory.Register(pbm.StorageBlackHole, driverFactory{})
+}
+
+// Driver discards every byte written to it.
+type Driver struct{}
+
+// Name returns the driver name.
+func (d *Driver) Name() string { return string(pbm.StorageBlackHole) }
+
+// Writer implements storagedriver.Driver. The returned writer discards
+// everything written to it.
+func (d *Driver) Writer(ctx context.Context, name string, append bool) (io.WriteCloser, error) {
+	return nopWriteCloser{ioutil.Discard}, nil
+}
+
+// Reader implements storagedriver.Driver. It always returns EOF.
+func (d *Driver) Reader(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	return ioutil.NopCloser(new(emptyReader)), nil
+}
+
+// Stat implements storagedriver.Driver.
+func (d *Driver) Stat(ctx context.Context, name string) (storagedriver.FileInfo, error) {
+	return storagedriver.FileInfo{}, errors.Errorf("<%s> does not exist in blackhole storage", name)
+}
+
+// List implements storagedriver.Driver. The blackhole never retains
+// anything, so it always returns an empty list.
+func (d *Driver) List(ctx context.Context, prefix string) ([]storagedriver.FileInfo, error) {
+	return nil, nil
+}
+
+// Delete implements storagedriver.Driver. It is always a no-op success.
+func (d *Driver) Delete(ctx context.Context, name string) error {
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type emptyReader struct{}
+
+func (emptyReader) Read(p []byte) (int, error) { return 0, io.EOF }
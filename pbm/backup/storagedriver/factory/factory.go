@@ -0,0 +1,52 @@
+// Package factory is a registry of storagedriver.Driver constructors,
+// keyed by storage type, so new backends can be added without touching
+// the backup/restore code paths.
+package factory
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver"
+)
+
+// Factory builds a storagedriver.Driver from a pbm.Storage config.
+// Backend packages implement this and register an instance with
+// Register in their init().
+type Factory interface {
+	Create(stg pbm.Storage) (storagedriver.Driver, error)
+}
+
+var (
+	mu        sync.Mutex
+	factories = make(map[pbm.StorageType]Factory)
+)
+
+// Register makes a Factory available under the given storage type.
+// It panics if f is nil or if Register is called twice for the same
+// name, following the database/sql driver registration pattern.
+func Register(name pbm.StorageType, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if f == nil {
+		panic("storagedriver/factory: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("storagedriver/factory: Register called twice for driver " + string(name))
+	}
+	factories[name] = f
+}
+
+// Create looks up the Factory registered for stg.Type and uses it to
+// build a Driver.
+func Create(stg pbm.Storage) (storagedriver.Driver, error) {
+	mu.Lock()
+	f, ok := factories[stg.Type]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("storagedriver/factory: no registered driver for storage type %q", stg.Type)
+	}
+	return f.Create(stg)
+}
@@ -0,0 +1,129 @@
+// Package fs implements a storagedriver.Driver backed by a local (or
+// NFS-mounted) filesystem path.
+package fs
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/factory"
+)
+
+type driverFactory struct{}
+
+func (driverFactory) Create(stg pbm.Storage) (storagedriver.Driver, error) {
+	if stg.Filesystem.Path == "" {
+		return nil, errors.New("filesystem storage path is not set")
+	}
+	return &Driver{root: stg.Filesystem.Path}, nil
+}
+
+func init() {
+	factory.Register(pbm.StorageFilesystem, driverFactory{})
+}
+
+// Driver stores backup files under root on the local filesystem.
+type Driver struct {
+	root string
+}
+
+// Name returns the driver name.
+func (d *Driver) Name() string { return string(pbm.StorageFilesystem) }
+
+func (d *Driver) fullpath(name string) string {
+	return path.Join(d.root, name)
+}
+
+// Writer implements storagedriver.Driver.
+func (d *Driver) Writer(ctx context.Context, name string, append bool) (io.WriteCloser, error) {
+	filepath := d.fullpath(name)
+	if err := os.MkdirAll(path.Dir(filepath), 0750); err != nil {
+		return nil, errors.Wrapf(err, "create destination dir for <%s>", filepath)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if append {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+	fw, err := os.OpenFile(filepath, flags, 0640)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open destination file <%s>", filepath)
+	}
+	return fw, nil
+}
+
+// Reader implements storagedriver.Driver.
+func (d *Driver) Reader(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	fr, err := os.Open(d.fullpath(name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "open source file <%s>", name)
+	}
+	if offset > 0 {
+		if _, err := fr.Seek(offset, io.SeekStart); err != nil {
+			fr.Close()
+			return nil, errors.Wrapf(err, "seek to offset %d in <%s>", offset, name)
+		}
+	}
+	return fr, nil
+}
+
+// Stat implements storagedriver.Driver.
+func (d *Driver) Stat(ctx context.Context, name string) (storagedriver.FileInfo, error) {
+	fi, err := os.Stat(d.fullpath(name))
+	if err != nil {
+		return storagedriver.FileInfo{}, errors.Wrapf(err, "stat <%s>", name)
+	}
+	return storagedriver.FileInfo{
+		Name:    name,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}, nil
+}
+
+// List implements storagedriver.Driver.
+func (d *Driver) List(ctx context.Context, prefix string) ([]storagedriver.FileInfo, error) {
+	root := d.fullpath(prefix)
+	var files []storagedriver.FileInfo
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(d.root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, storagedriver.FileInfo{
+			Name:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "walk <%s>", root)
+	}
+	return files, nil
+}
+
+// Delete implements storagedriver.Driver.
+func (d *Driver) Delete(ctx context.Context, name string) error {
+	err := os.Remove(d.fullpath(name))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "delete <%s>", name)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Package gcs implements a storagedriver.Driver backed natively by
+// cloud.google.com/go/storage, with resumable uploads so object size
+// isn't limited to a single PUT the way the minio-based S3-provider
+// path is.
+package gcs
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver"
+	"github.com/percona/percona-backup-mongodb/pbm/backup/storagedriver/factory"
+)
+
+// defaultChunkSize matches the GCS client library's own resumable
+// upload chunk granularity recommendation.
+const defaultChunkSize = 16 * 1024 * 1024
+
+type driverFactory struct{}
+
+func (driverFactory) Create(stg pbm.Storage) (storagedriver.Driver, error) {
+	var opts []option.ClientOption
+	switch {
+	case stg.GCS.CredentialsFile != "":
+		opts = append(opts, option.WithCredentialsFile(stg.GCS.CredentialsFile))
+	case len(stg.GCS.CredentialsJSON) > 0:
+		opts = append(opts, option.WithCredentialsJSON(stg.GCS.CredentialsJSON))
+	}
+	// otherwise fall back to ambient workload-identity / application
+	// default credentials, same as the client library does with no opts
+
+	client, err := storage.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "create GCS client")
+	}
+
+	chunkSize := stg.GCS.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	return &Driver{
+		client:    client,
+		bucket:    stg.GCS.Bucket,
+		prefix:    stg.GCS.Prefix,
+		chunkSize: chunkSize,
+	}, nil
+}
+
+func init() {
+	factory.Register(pbm.StorageGCSNative, driverFactory{})
+}
+
+// Driver talks to Google Cloud Storage via the native client, rather
+// than piggy-backing on minio-go the way the S3-provider GCS path does.
+type Driver struct {
+	client    *storage.Client
+	bucket    string
+	prefix    string
+	chunkSize int
+}
+
+// Name returns the driver name.
+func (d *Driver) Name() string { return string(pbm.StorageGCSNative) }
+
+func (d *Driver) key(name string) string {
+	return path.Join(d.prefix, name)
+}
+
+// Writer implements storagedriver.Driver with a resumable upload.
+// Appending is not supported: GCS objects are immutable once finalized.
+func (d *Driver) Writer(ctx context.Context, name string, append bool) (io.WriteCloser, error) {
+	if append {
+		return nil, errors.New("gcs: append is not supported, objects are immutable")
+	}
+
+	w := d.client.Bucket(d.bucket).Object(d.key(name)).NewWriter(ctx)
+	w.ChunkSize = d.chunkSize
+	w.ProgressFunc = func(bytesWritten int64) {
+		// no-op: upload progress is not reported anywhere, and the
+		// driver takes no callback from its caller to forward it to.
+	}
+	return w, nil
+}
+
+// Reader implements storagedriver.Driver using a ranged object read.
+func (d *Driver) Reader(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	r, err := d.client.Bucket(d.bucket).Object(d.key(name)).NewRangeReader(ctx, offset, -1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open reader for <%s>", name)
+	}
+	return r, nil
+}
+
+// Stat implements storagedriver.Driver.
+func (d *Driver) Stat(ctx context.Context, name string) (storagedriver.FileInfo, error) {
+	attrs, err := d.client.Bucket(d.bucket).Object(d.key(name)).Attrs(ctx)
+	if err != nil {
+		return storagedriver.FileInfo{}, errors.Wrapf(err, "stat <%s>", name)
+	}
+	return storagedriver.FileInfo{
+		Name:    name,
+		Size:    attrs.Size,
+		ModTime: attrs.Updated,
+	}, nil
+}
+
+// List implements storagedriver.Driver.
+func (d *Driver) List(ctx context.Context, prefix string) ([]storagedriver.FileInfo, error) {
+	var files []storagedriver.FileInfo
+	it := d.client.Bucket(d.bucket).Objects(ctx, &storage.Query{Prefix: d.key(prefix)})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "list objects in GCS")
+		}
+		files = append(files, storagedriver.FileInfo{
+			Name:    strings.TrimPrefix(attrs.Name, d.key("")+"/"),
+			Size:    attrs.Size,
+			ModTime: attrs.Updated,
+		})
+	}
+	return files, nil
+}
+
+// Delete implements storagedriver.Driver.
+func (d *Driver) Delete(ctx context.Context, name string) error {
+	err := d.client.Bucket(d.bucket).Object(d.key(name)).Delete(ctx)
+	return errors.Wrapf(err, "delete <%s>", name)
+}
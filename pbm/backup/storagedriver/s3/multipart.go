@@ -0,0 +1,348 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// s3API is the subset of *s3.S3 that multipartUpload calls. It exists so
+// tests can drive upload/abort with a fake instead of a live bucket;
+// *s3.S3 satisfies it without any change at the s3.New(sess) call site.
+type s3API interface {
+	CreateMultipartUploadWithContext(aws.Context, *s3.CreateMultipartUploadInput, ...request.Option) (*s3.CreateMultipartUploadOutput, error)
+	UploadPartWithContext(aws.Context, *s3.UploadPartInput, ...request.Option) (*s3.UploadPartOutput, error)
+	CompleteMultipartUploadWithContext(aws.Context, *s3.CompleteMultipartUploadInput, ...request.Option) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(*s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
+	PutObjectWithContext(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
+}
+
+const (
+	defaultPartSize    = 10 * 1024 * 1024 // 10MB, same default as the s3manager uploader it replaces
+	defaultConcurrency = 4
+	partUploadRetries  = 3
+)
+
+// multipartUpload drives an explicit CreateMultipartUpload / UploadPart /
+// CompleteMultipartUpload flow instead of handing the whole source to
+// s3manager.Uploader. A single producer goroutine reads fixed-size
+// chunks into buffers drawn from a sync.Pool and dispatches them to a
+// worker pool over a bounded channel, so memory use is at most partSize
+// * (maxInFlight + concurrency + 1) - the queued parts, one part per
+// worker and the one being filled - rather than partSize * total
+// parts. On the first worker
+// error the shared context is cancelled, the channel is drained, and
+// the upload is aborted so no orphan parts are left in the bucket.
+type multipartUpload struct {
+	svc    s3API
+	bucket string
+	key    string
+
+	partSize    int64
+	concurrency int
+	maxInFlight int
+
+	opts uploadOptions
+}
+
+// uploadOptions carries per-object S3 upload parameters (encryption,
+// storage class, checksum, ...) that are attached to
+// CreateMultipartUploadInput/UploadPartInput without widening the
+// multipartUpload constructor signature every time a new one is added.
+type uploadOptions struct {
+	sse          pbm.S3SSE
+	storageClass string
+	// checksum enables x-amz-checksum-crc32c validation on the
+	// multipart upload. It's opt-in rather than always-on because
+	// S3-compatible backends (Ceph RGW, older MinIO, Wasabi, ...) don't
+	// all implement the 2022 checksum algorithms API and reject
+	// CreateMultipartUpload/UploadPart/CompleteMultipartUpload calls
+	// that carry it.
+	checksum bool
+}
+
+func newMultipartUpload(sess *session.Session, bucket, key string, stg pbm.S3) *multipartUpload {
+	return newMultipartUploadWithAPI(s3.New(sess), bucket, key, stg)
+}
+
+// newMultipartUploadWithAPI builds a multipartUpload against an
+// arbitrary s3API, so tests can substitute a fake for the real *s3.S3
+// that newMultipartUpload would otherwise construct.
+func newMultipartUploadWithAPI(svc s3API, bucket, key string, stg pbm.S3) *multipartUpload {
+	partSize := stg.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	concurrency := stg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	maxInFlight := stg.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = concurrency * 2
+	}
+
+	return &multipartUpload{
+		svc:         svc,
+		bucket:      bucket,
+		key:         key,
+		partSize:    partSize,
+		concurrency: concurrency,
+		maxInFlight: maxInFlight,
+		opts: uploadOptions{
+			sse:          stg.SSE,
+			storageClass: stg.StorageClass,
+			checksum:     stg.EnableChecksum,
+		},
+	}
+}
+
+type partJob struct {
+	num int64
+	buf []byte
+}
+
+// upload reads r to completion and uploads it as a multipart object,
+// aborting the upload and returning the first error encountered on
+// failure.
+func (m *multipartUpload) upload(ctx context.Context, r io.Reader) error {
+	in := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(m.key),
+	}
+	m.applySSE(in)
+	if m.opts.storageClass != "" {
+		in.StorageClass = aws.String(m.opts.storageClass)
+	}
+	// CRC32C is validated by S3 itself when set on both the multipart
+	// upload and every part - see x-amz-checksum-crc32c. Opt-in only:
+	// not every S3-compatible endpoint supports it.
+	if m.opts.checksum {
+		in.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmCrc32c)
+	}
+
+	create, err := m.svc.CreateMultipartUploadWithContext(ctx, in)
+	if err != nil {
+		return errors.Wrap(err, "create multipart upload")
+	}
+	uploadID := create.UploadId
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	bufPool := &sync.Pool{New: func() interface{} { return make([]byte, m.partSize) }}
+	jobs := make(chan partJob, m.maxInFlight)
+
+	var (
+		mu       sync.Mutex
+		parts    []*s3.CompletedPart
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < m.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				part, err := m.uploadPartWithRetry(ctx, uploadID, job)
+				bufPool.Put(job.buf[:cap(job.buf)]) // #nosec - capacity is always partSize
+				if err != nil {
+					fail(err)
+					continue
+				}
+				mu.Lock()
+				parts = append(parts, part)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var partNum int64
+produce:
+	for {
+		buf := bufPool.Get().([]byte)
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			partNum++
+			select {
+			case jobs <- partJob{num: partNum, buf: buf[:n]}:
+			case <-ctx.Done():
+				bufPool.Put(buf)
+				break produce
+			}
+		} else {
+			bufPool.Put(buf)
+		}
+
+		switch rerr {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			break produce
+		default:
+			fail(errors.Wrap(rerr, "read source"))
+			break produce
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		m.abort(uploadID)
+		return firstErr
+	}
+
+	if len(parts) == 0 {
+		// A zero-byte source never produces a part, and S3 rejects
+		// CompleteMultipartUpload with an empty Parts list. Abort the
+		// upload and fall back to a plain PutObject, same as
+		// s3manager.Uploader does for small/empty bodies.
+		m.abort(uploadID)
+		return m.putEmpty(ctx)
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+	})
+	_, err = m.svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(m.bucket),
+		Key:             aws.String(m.key),
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		m.abort(uploadID)
+		return errors.Wrap(err, "complete multipart upload")
+	}
+
+	return nil
+}
+
+// putEmpty uploads a zero-byte object with PutObject, applying the same
+// SSE/storage-class options a multipart upload of the same object would
+// have used. See applySSE for why the switch below never actually has
+// to choose between SSE-C and SSE-KMS.
+func (m *multipartUpload) putEmpty(ctx context.Context) error {
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(m.key),
+		Body:   bytes.NewReader(nil),
+	}
+	if m.opts.storageClass != "" {
+		in.StorageClass = aws.String(m.opts.storageClass)
+	}
+	switch {
+	case m.opts.sse.CustomerKey != "":
+		in.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		in.SSECustomerKey = aws.String(m.opts.sse.CustomerKey)
+	case m.opts.sse.SSEAlgorithm != "":
+		in.ServerSideEncryption = aws.String(m.opts.sse.SSEAlgorithm)
+		if m.opts.sse.SSEAlgorithm == s3.ServerSideEncryptionAwsKms && m.opts.sse.SSEKMSKeyID != "" {
+			in.SSEKMSKeyId = aws.String(m.opts.sse.SSEKMSKeyID)
+		}
+	}
+
+	_, err := m.svc.PutObjectWithContext(ctx, in)
+	return errors.Wrap(err, "put empty object")
+}
+
+func (m *multipartUpload) uploadPartWithRetry(ctx context.Context, uploadID *string, job partJob) (*s3.CompletedPart, error) {
+	var lastErr error
+	for attempt := 0; attempt < partUploadRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(math.Pow(2, float64(attempt))) * time.Second
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		partIn := &s3.UploadPartInput{
+			Bucket:     aws.String(m.bucket),
+			Key:        aws.String(m.key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int64(job.num),
+			Body:       bytes.NewReader(job.buf),
+		}
+		if m.opts.checksum {
+			partIn.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmCrc32c)
+			partIn.ChecksumCRC32C = aws.String(crc32cBase64(job.buf))
+		}
+		if m.opts.sse.CustomerKey != "" {
+			partIn.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+			partIn.SSECustomerKey = aws.String(m.opts.sse.CustomerKey)
+		}
+		out, err := m.svc.UploadPartWithContext(ctx, partIn)
+		if err == nil {
+			return &s3.CompletedPart{
+				ETag:           out.ETag,
+				PartNumber:     aws.Int64(job.num),
+				ChecksumCRC32C: out.ChecksumCRC32C,
+			}, nil
+		}
+		lastErr = err
+	}
+	return nil, errors.Wrapf(lastErr, "upload part %d", job.num)
+}
+
+// applySSE sets the encryption fields on a CreateMultipartUploadInput.
+// SSE-C keys are set per-part on UploadPartInput instead, since AWS
+// requires the customer key on every part, not just upload creation.
+//
+// The switch below picks SSE-C over SSE-KMS when opts.sse somehow
+// carries both, but validateSSE already rejects that combination when
+// the driver is created. SSE-C together with SSEAlgorithm AES256 is not
+// rejected; the switch then applies SSE-C only.
+func (m *multipartUpload) applySSE(in *s3.CreateMultipartUploadInput) {
+	switch {
+	case m.opts.sse.CustomerKey != "":
+		in.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		in.SSECustomerKey = aws.String(m.opts.sse.CustomerKey)
+	case m.opts.sse.SSEAlgorithm != "":
+		in.ServerSideEncryption = aws.String(m.opts.sse.SSEAlgorithm)
+		if m.opts.sse.SSEAlgorithm == s3.ServerSideEncryptionAwsKms && m.opts.sse.SSEKMSKeyID != "" {
+			in.SSEKMSKeyId = aws.String(m.opts.sse.SSEKMSKeyID)
+		}
+	}
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// crc32cBase64 computes the base64-encoded CRC32C of b, the form S3
+// expects on ChecksumCRC32C.
+func crc32cBase64(b []byte) string {
+	sum := crc32.Checksum(b, crc32cTable)
+	var buf [4]byte
+	buf[0], buf[1], buf[2], buf[3] = byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum)
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
+
+func (m *multipartUpload) abort(uploadID *string) {
+	_, _ = m.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(m.bucket),
+		Key:      aws.String(m.key),
+		UploadId: uploadID,
+	})
+}
@@ -0,0 +1,167 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// fakeS3API is a minimal in-memory s3API used to drive multipartUpload
+// without a real bucket. It's only as smart as the tests below need.
+type fakeS3API struct {
+	mu sync.Mutex
+
+	// failPart, if set, is returned as an error from every
+	// UploadPartWithContext call for that part number.
+	failPart map[int64]error
+
+	parts        map[int64][]byte
+	createCalls  int
+	completeCall *s3.CompleteMultipartUploadInput
+	abortCalls   int
+	putObjCalls  []*s3.PutObjectInput
+}
+
+func newFakeS3API() *fakeS3API {
+	return &fakeS3API{
+		failPart: make(map[int64]error),
+		parts:    make(map[int64][]byte),
+	}
+}
+
+func (f *fakeS3API) CreateMultipartUploadWithContext(aws.Context, *s3.CreateMultipartUploadInput, ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.createCalls++
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (f *fakeS3API) UploadPartWithContext(_ aws.Context, in *s3.UploadPartInput, _ ...request.Option) (*s3.UploadPartOutput, error) {
+	partNum := aws.Int64Value(in.PartNumber)
+
+	f.mu.Lock()
+	err := f.failPart[partNum]
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := readAllSeeker(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.parts[partNum] = buf
+	f.mu.Unlock()
+	return &s3.UploadPartOutput{ETag: aws.String("etag")}, nil
+}
+
+func (f *fakeS3API) CompleteMultipartUploadWithContext(_ aws.Context, in *s3.CompleteMultipartUploadInput, _ ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.completeCall = in
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3API) AbortMultipartUpload(*s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.abortCalls++
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3API) PutObjectWithContext(_ aws.Context, in *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.putObjCalls = append(f.putObjCalls, in)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func readAllSeeker(r io.ReadSeeker) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func TestMultipartUploadSuccess(t *testing.T) {
+	svc := newFakeS3API()
+	m := newMultipartUploadWithAPI(svc, "bucket", "key", pbm.S3{PartSize: 4, Concurrency: 1})
+
+	data := []byte("0123456789ab") // 3 parts of 4 bytes at partSize=4
+	err := m.upload(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	if svc.createCalls != 1 {
+		t.Fatalf("expected 1 CreateMultipartUpload call, got %d", svc.createCalls)
+	}
+	if svc.abortCalls != 0 {
+		t.Fatalf("expected no abort on success, got %d", svc.abortCalls)
+	}
+	if svc.completeCall == nil {
+		t.Fatal("expected CompleteMultipartUpload to be called")
+	}
+
+	var got bytes.Buffer
+	for _, p := range svc.completeCall.MultipartUpload.Parts {
+		got.Write(svc.parts[aws.Int64Value(p.PartNumber)])
+	}
+	if got.String() != string(data) {
+		t.Fatalf("reassembled parts = %q, want %q", got.String(), data)
+	}
+}
+
+func TestMultipartUploadWorkerErrorAborts(t *testing.T) {
+	svc := newFakeS3API()
+	svc.failPart[2] = errors.New("boom")
+	m := newMultipartUploadWithAPI(svc, "bucket", "key", pbm.S3{PartSize: 4, Concurrency: 1})
+
+	data := []byte("0123456789ab")
+	err := m.upload(context.Background(), bytes.NewReader(data))
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	if !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected error to wrap the worker error, got %v", err)
+	}
+	if svc.abortCalls != 1 {
+		t.Fatalf("expected 1 abort call, got %d", svc.abortCalls)
+	}
+	if svc.completeCall != nil {
+		t.Fatal("expected CompleteMultipartUpload not to be called")
+	}
+}
+
+func TestMultipartUploadZeroByteFallsBackToPutObject(t *testing.T) {
+	svc := newFakeS3API()
+	m := newMultipartUploadWithAPI(svc, "bucket", "key", pbm.S3{PartSize: 4, Concurrency: 1})
+
+	err := m.upload(context.Background(), bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	if len(svc.putObjCalls) != 1 {
+		t.Fatalf("expected 1 PutObject call, got %d", len(svc.putObjCalls))
+	}
+	if svc.abortCalls != 1 {
+		t.Fatalf("expected the empty multipart upload to be aborted, got %d aborts", svc.abortCalls)
+	}
+	if svc.completeCall != nil {
+		t.Fatal("expected CompleteMultipartUpload not to be called for a zero-byte source")
+	}
+}
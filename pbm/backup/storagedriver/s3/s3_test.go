@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestValidateSSE(t *testing.T) {
+	tests := []struct {
+		name    string
+		sse     pbm.S3SSE
+		wantErr bool
+	}{
+		{
+			name: "no encryption",
+			sse:  pbm.S3SSE{},
+		},
+		{
+			name: "SSE-C only",
+			sse:  pbm.S3SSE{CustomerKey: "customer-key"},
+		},
+		{
+			name: "SSE-KMS only",
+			sse:  pbm.S3SSE{SSEAlgorithm: "aws:kms", SSEKMSKeyID: "key-id"},
+		},
+		{
+			name: "SSE-AES256 only",
+			sse:  pbm.S3SSE{SSEAlgorithm: "AES256"},
+		},
+		{
+			name:    "SSE-KMS and SSE-C together is rejected",
+			sse:     pbm.S3SSE{SSEAlgorithm: "aws:kms", CustomerKey: "customer-key"},
+			wantErr: true,
+		},
+		{
+			name:    "SSEKMSKeyID without aws:kms is rejected",
+			sse:     pbm.S3SSE{SSEAlgorithm: "AES256", SSEKMSKeyID: "key-id"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSSE(pbm.S3{SSE: tt.sse})
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected an error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
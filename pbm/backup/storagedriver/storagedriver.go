@@ -0,0 +1,53 @@
+// Package storagedriver defines the interface that pluggable backup
+// storage backends implement. It is modeled after Docker's
+// distribution/registry storage drivers: each backend (filesystem, S3,
+// GCS, Azure, ...) lives in its own sub-package and registers a factory
+// with pbm/backup/storagedriver/factory, so the backup/restore code
+// never needs to know which backend it is talking to.
+package storagedriver
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// FileInfo describes a single object stored by a Driver.
+type FileInfo struct {
+	// Name is the object's path relative to the storage root/prefix.
+	Name string
+	// Size is the object size in bytes. For directories it is whatever
+	// the backend reports, which is not necessarily zero.
+	Size int64
+	// ModTime is the last modification time, if the backend reports one.
+	ModTime time.Time
+	// IsDir reports whether Name is a directory-like prefix rather than
+	// a leaf object.
+	IsDir bool
+}
+
+// Driver is a storage backend capable of streaming backup artifacts in
+// and out. Implementations must be safe for concurrent use.
+type Driver interface {
+	// Name returns the driver name, as passed to factory.Register.
+	Name() string
+
+	// Writer returns a writer that streams data to name. If append is
+	// true and name already exists, writes continue from its current
+	// end (used for incremental PITR oplog chunks); otherwise name is
+	// created or truncated.
+	Writer(ctx context.Context, name string, append bool) (io.WriteCloser, error)
+
+	// Reader returns a reader streaming the content of name starting at
+	// offset.
+	Reader(ctx context.Context, name string, offset int64) (io.ReadCloser, error)
+
+	// Stat returns the FileInfo for name.
+	Stat(ctx context.Context, name string) (FileInfo, error)
+
+	// List returns the FileInfo of every object stored under prefix.
+	List(ctx context.Context, prefix string) ([]FileInfo, error)
+
+	// Delete removes name from the backend.
+	Delete(ctx context.Context, name string) error
+}